@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/armon/go-socks5"
+)
+
+// aclRuleSet implements socks5.RuleSet, restricting which destinations a
+// relayed SOCKS5 session is allowed to dial based on operator-supplied
+// allow/deny CIDRs, allowed port ranges, and allow/deny DNS suffixes. When no
+// ACL flags are given, newACLRuleSet returns a nil socks5.RuleSet instead of
+// an *aclRuleSet; Allow itself must not be called on a nil *aclRuleSet.
+type aclRuleSet struct {
+	allowNets     []*net.IPNet
+	denyNets      []*net.IPNet
+	allowPorts    []portRange
+	allowSuffixes []string
+	denySuffixes  []string
+}
+
+type portRange struct {
+	lo, hi uint16
+}
+
+// newACLRuleSet builds a RuleSet from the raw --allow-cidr, --deny-cidr,
+// --allow-port, --allow-suffix and --deny-suffix flag values. It returns nil
+// when none of them are set, so the SOCKS5 server falls back to its
+// permissive default.
+func newACLRuleSet(allowCIDRs, denyCIDRs, allowPorts, allowSuffixes, denySuffixes []string) (socks5.RuleSet, error) {
+	if len(allowCIDRs) == 0 && len(denyCIDRs) == 0 && len(allowPorts) == 0 &&
+		len(allowSuffixes) == 0 && len(denySuffixes) == 0 {
+		return nil, nil
+	}
+
+	rules := &aclRuleSet{
+		allowSuffixes: normalizeSuffixes(allowSuffixes),
+		denySuffixes:  normalizeSuffixes(denySuffixes),
+	}
+
+	for _, raw := range allowCIDRs {
+		n, err := parseCIDR(raw)
+		if err != nil {
+			return nil, fmt.Errorf("allow-cidr: %w", err)
+		}
+
+		rules.allowNets = append(rules.allowNets, n)
+	}
+
+	for _, raw := range denyCIDRs {
+		n, err := parseCIDR(raw)
+		if err != nil {
+			return nil, fmt.Errorf("deny-cidr: %w", err)
+		}
+
+		rules.denyNets = append(rules.denyNets, n)
+	}
+
+	for _, raw := range allowPorts {
+		pr, err := parsePortRange(raw)
+		if err != nil {
+			return nil, fmt.Errorf("allow-port: %w", err)
+		}
+
+		rules.allowPorts = append(rules.allowPorts, pr)
+	}
+
+	return rules, nil
+}
+
+func parseCIDR(raw string) (*net.IPNet, error) {
+	// A bare IP (no "/") is shorthand for a /32 (or /128) rule.
+	if !strings.Contains(raw, "/") {
+		ip := net.ParseIP(raw)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid CIDR or IP %q", raw)
+		}
+
+		bits := 32
+		if ip.To4() == nil {
+			bits = 128
+		}
+
+		raw = fmt.Sprintf("%s/%d", raw, bits)
+	}
+
+	_, n, err := net.ParseCIDR(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR %q: %w", raw, err)
+	}
+
+	return n, nil
+}
+
+// normalizeSuffixes lowercases each suffix and strips a leading ".", so
+// "Example.com" and ".example.com" compare the same way hasSuffix does.
+func normalizeSuffixes(raw []string) []string {
+	if len(raw) == 0 {
+		return nil
+	}
+
+	out := make([]string, len(raw))
+	for i, s := range raw {
+		out[i] = strings.ToLower(strings.TrimPrefix(s, "."))
+	}
+
+	return out
+}
+
+// hasSuffix reports whether fqdn is, or is a subdomain of, one of suffixes.
+func hasSuffix(fqdn string, suffixes []string) bool {
+	fqdn = strings.ToLower(strings.TrimSuffix(fqdn, "."))
+
+	for _, s := range suffixes {
+		if fqdn == s || strings.HasSuffix(fqdn, "."+s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parsePortRange(raw string) (portRange, error) {
+	lo, hi, hasRange := strings.Cut(raw, "-")
+
+	loPort, err := strconv.ParseUint(lo, 10, 16)
+	if err != nil {
+		return portRange{}, fmt.Errorf("invalid port %q", lo)
+	}
+
+	if !hasRange {
+		return portRange{lo: uint16(loPort), hi: uint16(loPort)}, nil
+	}
+
+	hiPort, err := strconv.ParseUint(hi, 10, 16)
+	if err != nil {
+		return portRange{}, fmt.Errorf("invalid port %q", hi)
+	}
+
+	return portRange{lo: uint16(loPort), hi: uint16(hiPort)}, nil
+}
+
+// Allow implements socks5.RuleSet. A request is rejected when its
+// destination FQDN matches a deny suffix, when allow suffixes are configured
+// and it matches none of them, when its destination IP matches a deny CIDR,
+// when allow CIDRs are configured and it matches none of them, or when
+// allowed ports are configured and the destination port falls outside all
+// of them.
+func (r *aclRuleSet) Allow(ctx context.Context, req *socks5.Request) (context.Context, bool) {
+	if req.DestAddr.FQDN != "" {
+		if len(r.denySuffixes) > 0 && hasSuffix(req.DestAddr.FQDN, r.denySuffixes) {
+			return ctx, false
+		}
+
+		if len(r.allowSuffixes) > 0 && !hasSuffix(req.DestAddr.FQDN, r.allowSuffixes) {
+			return ctx, false
+		}
+	}
+
+	ip := req.DestAddr.IP
+	if ip == nil {
+		ips, err := net.LookupIP(req.DestAddr.FQDN)
+		if err != nil || len(ips) == 0 {
+			return ctx, false
+		}
+
+		ip = ips[0]
+
+		// Pin the request to the address we're about to check: go-socks5
+		// dials req.DestAddr.Address() after Allow returns, which re-resolves
+		// the FQDN if we leave it set. A second DNS answer that differs from
+		// this one would then dial an IP the rules never actually saw.
+		req.DestAddr.IP = ip
+	}
+
+	for _, n := range r.denyNets {
+		if n.Contains(ip) {
+			return ctx, false
+		}
+	}
+
+	if len(r.allowNets) > 0 && !anyContains(r.allowNets, ip) {
+		return ctx, false
+	}
+
+	if len(r.allowPorts) > 0 && !portInRange(r.allowPorts, uint16(req.DestAddr.Port)) {
+		return ctx, false
+	}
+
+	return ctx, true
+}
+
+func anyContains(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func portInRange(ranges []portRange, port uint16) bool {
+	for _, pr := range ranges {
+		if port >= pr.lo && port <= pr.hi {
+			return true
+		}
+	}
+
+	return false
+}
+
+// addrSpecFromHostPort builds the socks5.AddrSpec a RuleSet expects out of a
+// "host:port" string, for callers (the framed CONNECT path) that never go
+// through go-socks5's own request parsing.
+func addrSpecFromHostPort(hostPort string) (*socks5.AddrSpec, error) {
+	host, portStr, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return nil, fmt.Errorf("invalid host:port %q: %w", hostPort, err)
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port in %q: %w", hostPort, err)
+	}
+
+	addr := &socks5.AddrSpec{Port: port}
+	if ip := net.ParseIP(host); ip != nil {
+		addr.IP = ip
+	} else {
+		addr.FQDN = host
+	}
+
+	return addr, nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/armon/go-socks5"
+)
+
+func TestParseCIDR(t *testing.T) {
+	cases := []struct {
+		raw     string
+		wantErr bool
+	}{
+		{"10.0.0.0/8", false},
+		{"192.168.1.1", false}, // bare IP shorthand for /32
+		{"::1", false},
+		{"not-a-cidr", true},
+	}
+
+	for _, c := range cases {
+		_, err := parseCIDR(c.raw)
+		if (err != nil) != c.wantErr {
+			t.Errorf("parseCIDR(%q) error = %v, wantErr %v", c.raw, err, c.wantErr)
+		}
+	}
+}
+
+func TestParsePortRange(t *testing.T) {
+	cases := []struct {
+		raw     string
+		lo, hi  uint16
+		wantErr bool
+	}{
+		{"443", 443, 443, false},
+		{"8000-8100", 8000, 8100, false},
+		{"not-a-port", 0, 0, true},
+		{"80-not-a-port", 0, 0, true},
+	}
+
+	for _, c := range cases {
+		pr, err := parsePortRange(c.raw)
+		if (err != nil) != c.wantErr {
+			t.Errorf("parsePortRange(%q) error = %v, wantErr %v", c.raw, err, c.wantErr)
+
+			continue
+		}
+
+		if err == nil && (pr.lo != c.lo || pr.hi != c.hi) {
+			t.Errorf("parsePortRange(%q) = %+v, want {%d %d}", c.raw, pr, c.lo, c.hi)
+		}
+	}
+}
+
+func TestACLRuleSetAllow(t *testing.T) {
+	rules := &aclRuleSet{
+		denyNets:   mustNets(t, "10.0.0.0/8"),
+		allowNets:  mustNets(t, "192.168.0.0/16"),
+		allowPorts: []portRange{{lo: 443, hi: 443}},
+	}
+
+	cases := []struct {
+		name string
+		ip   net.IP
+		port int
+		want bool
+	}{
+		{"denied network", net.ParseIP("10.1.2.3"), 443, false},
+		{"not in allow network", net.ParseIP("8.8.8.8"), 443, false},
+		{"disallowed port", net.ParseIP("192.168.1.1"), 80, false},
+		{"allowed", net.ParseIP("192.168.1.1"), 443, true},
+	}
+
+	for _, c := range cases {
+		req := &socks5.Request{DestAddr: &socks5.AddrSpec{IP: c.ip, Port: c.port}}
+
+		_, got := rules.Allow(context.Background(), req)
+		if got != c.want {
+			t.Errorf("%s: Allow() = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestACLRuleSetSuffixMatch(t *testing.T) {
+	rules := &aclRuleSet{
+		denySuffixes:  []string{"evil.example.com"},
+		allowSuffixes: []string{"example.com"},
+	}
+
+	cases := []struct {
+		name string
+		fqdn string
+		want bool
+	}{
+		{"denied suffix", "evil.example.com", false},
+		{"denied subdomain", "api.evil.example.com", false},
+		{"allowed suffix", "good.example.com", true},
+		{"exact allowed domain", "example.com", true},
+		{"not in allow suffix", "example.org", false},
+	}
+
+	for _, c := range cases {
+		req := &socks5.Request{DestAddr: &socks5.AddrSpec{FQDN: c.fqdn, IP: net.ParseIP("203.0.113.1"), Port: 443}}
+
+		_, got := rules.Allow(context.Background(), req)
+		if got != c.want {
+			t.Errorf("%s: Allow() = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestACLRuleSetPinsResolvedIP(t *testing.T) {
+	rules := &aclRuleSet{allowNets: mustNets(t, "127.0.0.0/8")}
+
+	req := &socks5.Request{DestAddr: &socks5.AddrSpec{FQDN: "localhost", Port: 80}}
+
+	_, ok := rules.Allow(context.Background(), req)
+	if !ok {
+		t.Fatalf("Allow() = false, want true for a resolvable, permitted FQDN")
+	}
+
+	if req.DestAddr.IP == nil {
+		t.Fatalf("Allow() did not pin req.DestAddr.IP, the real dial would re-resolve the FQDN independently")
+	}
+}
+
+func TestAddrSpecFromHostPort(t *testing.T) {
+	addr, err := addrSpecFromHostPort("example.internal:8443")
+	if err != nil {
+		t.Fatalf("addrSpecFromHostPort: %v", err)
+	}
+
+	if addr.FQDN != "example.internal" || addr.Port != 8443 {
+		t.Fatalf("addrSpecFromHostPort() = %+v, want FQDN example.internal, port 8443", addr)
+	}
+
+	addr, err = addrSpecFromHostPort("192.168.1.1:443")
+	if err != nil {
+		t.Fatalf("addrSpecFromHostPort: %v", err)
+	}
+
+	if addr.IP.String() != "192.168.1.1" || addr.Port != 443 {
+		t.Fatalf("addrSpecFromHostPort() = %+v, want IP 192.168.1.1, port 443", addr)
+	}
+
+	if _, err := addrSpecFromHostPort("not-a-host-port"); err == nil {
+		t.Fatalf("expected an error for a string with no port")
+	}
+}
+
+func mustNets(t *testing.T, raw ...string) []*net.IPNet {
+	t.Helper()
+
+	nets := make([]*net.IPNet, 0, len(raw))
+
+	for _, r := range raw {
+		n, err := parseCIDR(r)
+		if err != nil {
+			t.Fatalf("parseCIDR(%q): %v", r, err)
+		}
+
+		nets = append(nets, n)
+	}
+
+	return nets
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/armon/go-socks5"
+)
+
+// Proxy protocols the relay can serve over the yamux session, selected with
+// --protocol.
+const (
+	protocolSOCKS5 = "socks5"
+	protocolHTTP   = "http"
+	protocolBoth   = "both"
+)
+
+// streamTagTimeout bounds how long dispatchingListener waits for a stream's
+// one-byte kind tag before giving up on it. Without a deadline, a peer that
+// never sends its tag (slow, half-open, or just buggy) would block forever.
+const streamTagTimeout = 10 * time.Second
+
+// dispatchingListener wraps a yamux session in --protocol=both mode. Every
+// accepted stream starts with a one-byte streamKind tag written by the
+// listener; framed CONNECT streams are handled here directly (checked
+// against rules and dialed through dial, the same ACL and upstream the
+// SOCKS5 path uses), while SOCKS5 streams (tag stripped) are handed back to
+// the caller so they can be served by the regular go-socks5 server.
+//
+// Each accepted stream's tag is read in its own goroutine under
+// streamTagTimeout, off the Accept() hot path: a stream that never sends its
+// tag would otherwise stall Accept() indefinitely and, with it, every other
+// stream multiplexed over the same session.
+type dispatchingListener struct {
+	net.Listener
+
+	dial    dialFunc
+	rules   socks5.RuleSet
+	timeout time.Duration
+
+	once      sync.Once
+	socks     chan net.Conn
+	acceptErr chan error
+}
+
+func (d *dispatchingListener) init() {
+	d.socks = make(chan net.Conn)
+	d.acceptErr = make(chan error, 1)
+
+	go func() {
+		for {
+			stream, err := d.Listener.Accept()
+			if err != nil {
+				d.acceptErr <- err
+
+				return
+			}
+
+			go d.route(stream)
+		}
+	}()
+}
+
+// route reads a single stream's kind tag under a deadline and dispatches it:
+// framed CONNECT streams are handled in place, SOCKS5 streams are handed to
+// Accept(), and anything else (including a tag read that times out) is
+// closed without affecting any other stream.
+func (d *dispatchingListener) route(stream net.Conn) {
+	if err := stream.SetReadDeadline(time.Now().Add(streamTagTimeout)); err != nil {
+		stream.Close() //nolint:errcheck
+
+		return
+	}
+
+	var tag [1]byte
+	if _, err := io.ReadFull(stream, tag[:]); err != nil {
+		stream.Close() //nolint:errcheck
+
+		return
+	}
+
+	if err := stream.SetReadDeadline(time.Time{}); err != nil {
+		stream.Close() //nolint:errcheck
+
+		return
+	}
+
+	switch streamKind(tag[0]) {
+	case streamKindConnect:
+		handleFramedConnect(stream, d.dial, d.rules, d.timeout)
+	case streamKindSOCKS5:
+		d.socks <- stream
+	default:
+		stream.Close() //nolint:errcheck
+	}
+}
+
+func (d *dispatchingListener) Accept() (net.Conn, error) {
+	d.once.Do(d.init)
+
+	select {
+	case stream := <-d.socks:
+		return stream, nil
+	case err := <-d.acceptErr:
+		return nil, err
+	}
+}
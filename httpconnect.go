@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/armon/go-socks5"
+)
+
+// serveFramedConnect accepts streams from l, each carrying a connect frame
+// (see proxyframe.go) identifying the host:port the listener wants reached,
+// checks it against rules, dials it through dial, and relays bytes in both
+// directions. It never returns HTTP to the stream itself: the HTTP CONNECT
+// handshake is handled on the listener side, which only hands the relay the
+// resolved destination, keeping go-socks5 out of the HTTP CONNECT path
+// entirely.
+func serveFramedConnect(l net.Listener, dial dialFunc, rules socks5.RuleSet, timeout time.Duration) error {
+	for {
+		stream, err := l.Accept()
+		if err != nil {
+			return fmt.Errorf("accept stream: %w", err)
+		}
+
+		go handleFramedConnect(stream, dial, rules, timeout)
+	}
+}
+
+// directDial is the dialFunc used when no --upstream is configured.
+func directDial(ctx context.Context, network, addr string) (net.Conn, error) {
+	return (&net.Dialer{}).DialContext(ctx, network, addr)
+}
+
+// handleFramedConnect reads a connect frame off stream, checks it against
+// rules (the same ACL the SOCKS5 path enforces - a nil rules allows
+// everything), and relays it to dial. A nil dial falls back to a direct
+// net.Dialer, same as go-socks5's default - callers (serveFramedConnect and
+// dispatchingListener) both pass through socksConfig.Dial, which is nil
+// whenever --upstream isn't set.
+func handleFramedConnect(stream net.Conn, dial dialFunc, rules socks5.RuleSet, timeout time.Duration) {
+	defer stream.Close() //nolint:errcheck
+
+	if dial == nil {
+		dial = directDial
+	}
+
+	hostPort, err := readConnectFrame(stream)
+	if err != nil {
+		fmt.Printf("connect: read frame: %v\n", err)
+
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if rules != nil {
+		addr, err := addrSpecFromHostPort(hostPort)
+		if err != nil {
+			fmt.Printf("connect: %v\n", err)
+
+			return
+		}
+
+		if _, ok := rules.Allow(ctx, &socks5.Request{DestAddr: addr}); !ok {
+			fmt.Printf("connect: %s rejected by ACL\n", hostPort)
+
+			return
+		}
+
+		// Allow may have resolved addr.FQDN and pinned addr.IP (see acl.go);
+		// dial that exact address instead of the original host:port so the
+		// real egress can't re-resolve to a different, unchecked IP.
+		if addr.IP != nil {
+			hostPort = net.JoinHostPort(addr.IP.String(), fmt.Sprintf("%d", addr.Port))
+		}
+	}
+
+	dst, err := dial(ctx, "tcp", hostPort)
+	if err != nil {
+		fmt.Printf("connect: dial %s: %v\n", hostPort, err)
+
+		return
+	}
+
+	defer dst.Close() //nolint:errcheck
+
+	pipe(stream, dst)
+}
+
+// pipe copies bytes between a and b until either side is closed.
+func pipe(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+
+	go func() {
+		io.Copy(a, b) //nolint:errcheck
+
+		done <- struct{}{}
+	}()
+
+	go func() {
+		io.Copy(b, a) //nolint:errcheck
+
+		done <- struct{}{}
+	}()
+
+	<-done
+}
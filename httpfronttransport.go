@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// httpFrontTransport reaches the listener through an HTTP/1.1 CONNECT tunnel
+// opened against a fronting host, then performs the TLS handshake for the
+// real listener address inside that tunnel. The TCP connection on the wire
+// goes to the front's IP, but the hop to the front is not encrypted: the
+// CONNECT request naming the listener address and the inner TLS ClientHello
+// (with its SNI) are both sent to the front in the clear.
+type httpFrontTransport struct {
+	cfg transportConfig
+}
+
+func (t *httpFrontTransport) Dial(ctx context.Context) (io.ReadWriteCloser, error) {
+	frontAddr := t.cfg.front
+	if frontAddr == "" {
+		frontAddr = t.cfg.connectBackAddr
+	}
+
+	conn, err := (&net.Dialer{Timeout: t.cfg.timeout}).DialContext(ctx, "tcp", frontAddr)
+	if err != nil {
+		return nil, fmt.Errorf("dial front %s: %w", frontAddr, err)
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: t.cfg.connectBackAddr},
+		Host:   t.cfg.connectBackAddr,
+		Header: make(http.Header),
+	}
+
+	if err := req.Write(conn); err != nil {
+		conn.Close() //nolint:errcheck
+
+		return nil, fmt.Errorf("write CONNECT request: %w", err)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close() //nolint:errcheck
+
+		return nil, fmt.Errorf("read CONNECT response: %w", err)
+	}
+
+	defer resp.Body.Close() //nolint:errcheck
+
+	if resp.StatusCode != http.StatusOK {
+		conn.Close() //nolint:errcheck
+
+		return nil, fmt.Errorf("front %s refused CONNECT: %s", frontAddr, resp.Status)
+	}
+
+	tlsConfig := t.cfg.tlsConfig.Clone()
+	if t.cfg.sni != "" {
+		tlsConfig.ServerName = t.cfg.sni
+	}
+
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close() //nolint:errcheck
+
+		return nil, fmt.Errorf("tls handshake through front: %w", err)
+	}
+
+	return tlsConn, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// streamKind tags the first byte of a yamux stream when the relay is
+// dispatching between more than one proxy protocol (--protocol=both). It is
+// only sent by the listener in that mode: a plain SOCKS5 or HTTP CONNECT
+// deployment never sees it, so existing single-protocol relays and listeners
+// keep interoperating unmodified.
+type streamKind byte
+
+const (
+	streamKindSOCKS5  streamKind = 0x00
+	streamKindConnect streamKind = 0x01
+)
+
+// maxFramedHostPort bounds the size of a framed CONNECT target so a
+// misbehaving or confused peer can't make the relay allocate an unbounded
+// buffer.
+const maxFramedHostPort = 512
+
+// encodeConnectFrame builds the tiny header the listener writes at the start
+// of a stream in framed-CONNECT mode: a 2-byte big-endian length followed by
+// the "host:port" the relay should dial on the listener's behalf.
+func encodeConnectFrame(hostPort string) ([]byte, error) {
+	if len(hostPort) > maxFramedHostPort {
+		return nil, fmt.Errorf("host:port %q exceeds %d bytes", hostPort, maxFramedHostPort)
+	}
+
+	frame := make([]byte, 2+len(hostPort))
+	binary.BigEndian.PutUint16(frame, uint16(len(hostPort)))
+	copy(frame[2:], hostPort)
+
+	return frame, nil
+}
+
+// readConnectFrame reads the header written by encodeConnectFrame off r.
+func readConnectFrame(r io.Reader) (string, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return "", fmt.Errorf("read frame length: %w", err)
+	}
+
+	n := binary.BigEndian.Uint16(length[:])
+	if n == 0 || int(n) > maxFramedHostPort {
+		return "", fmt.Errorf("invalid framed host:port length %d", n)
+	}
+
+	hostPort := make([]byte, n)
+	if _, err := io.ReadFull(r, hostPort); err != nil {
+		return "", fmt.Errorf("read host:port: %w", err)
+	}
+
+	return string(hostPort), nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEncodeDecodeConnectFrame(t *testing.T) {
+	const hostPort = "example.internal:8443"
+
+	frame, err := encodeConnectFrame(hostPort)
+	if err != nil {
+		t.Fatalf("encodeConnectFrame: %v", err)
+	}
+
+	got, err := readConnectFrame(bytes.NewReader(frame))
+	if err != nil {
+		t.Fatalf("readConnectFrame: %v", err)
+	}
+
+	if got != hostPort {
+		t.Fatalf("readConnectFrame() = %q, want %q", got, hostPort)
+	}
+}
+
+func TestEncodeConnectFrameRejectsOversizedHostPort(t *testing.T) {
+	huge := strings.Repeat("a", maxFramedHostPort+1)
+
+	if _, err := encodeConnectFrame(huge); err == nil {
+		t.Fatalf("expected an error for a host:port longer than %d bytes", maxFramedHostPort)
+	}
+}
+
+func TestReadConnectFrameRejectsTruncatedInput(t *testing.T) {
+	if _, err := readConnectFrame(bytes.NewReader([]byte{0x00})); err == nil {
+		t.Fatalf("expected an error reading a truncated length prefix")
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	mathrand "math/rand"
+	"time"
+
+	"github.com/hashicorp/yamux"
+)
+
+const sessionNonceSize = 16
+
+// generateSessionNonce creates the short random value sent once per relay
+// process as the first frame after the yamux handshake, so the listener can
+// recognize a reconnecting relay as the same logical session instead of
+// treating every flap as a brand-new pivot.
+func generateSessionNonce() ([]byte, error) {
+	nonce := make([]byte, sessionNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generate session nonce: %w", err)
+	}
+
+	return nonce, nil
+}
+
+// sendSessionNonce opens a throwaway yamux stream carrying the session nonce
+// as its entire payload, length-prefixed the same way proxyframe.go frames
+// its host:port headers. It must be the first stream opened on a freshly
+// established session.
+func sendSessionNonce(session *yamux.Session, nonce []byte) error {
+	stream, err := session.Open()
+	if err != nil {
+		return fmt.Errorf("open nonce stream: %w", err)
+	}
+
+	defer stream.Close() //nolint:errcheck
+
+	frame := make([]byte, 2+len(nonce))
+	binary.BigEndian.PutUint16(frame, uint16(len(nonce)))
+	copy(frame[2:], nonce)
+
+	if _, err := stream.Write(frame); err != nil {
+		return fmt.Errorf("write session nonce: %w", err)
+	}
+
+	return nil
+}
+
+// backoff computes the delay before the next reconnect attempt: exponential
+// growth from min up to max, with jitter that scales each delay to between
+// half and all of its computed value so a fleet of flapping relays doesn't
+// all reconnect in lockstep, plus an optional total retry budget.
+type backoff struct {
+	min, max   time.Duration
+	maxRetries int
+	attempt    int
+}
+
+func newBackoff(min, max time.Duration, maxRetries int) *backoff {
+	return &backoff{min: min, max: max, maxRetries: maxRetries}
+}
+
+// next returns the delay before the next attempt, or false once maxRetries
+// (when set) has been exhausted.
+func (b *backoff) next() (time.Duration, bool) {
+	if b.maxRetries > 0 && b.attempt >= b.maxRetries {
+		return 0, false
+	}
+
+	delay := b.min << b.attempt
+	if delay <= 0 || delay > b.max {
+		delay = b.max
+	}
+
+	b.attempt++
+
+	return time.Duration(float64(delay) * (0.5 + mathrand.Float64()*0.5)), true
+}
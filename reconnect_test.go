@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffGrowsExponentiallyUpToMax(t *testing.T) {
+	b := newBackoff(1*time.Second, 8*time.Second, 0)
+
+	var last time.Duration
+
+	for i := 0; i < 10; i++ {
+		delay, ok := b.next()
+		if !ok {
+			t.Fatalf("next() returned ok=false with no retry budget set")
+		}
+
+		if delay > 8*time.Second {
+			t.Fatalf("delay %v exceeded backoff-max", delay)
+		}
+
+		last = delay
+	}
+
+	if last < 4*time.Second {
+		t.Fatalf("expected delay to have climbed toward backoff-max, got %v", last)
+	}
+}
+
+func TestBackoffRespectsMaxRetries(t *testing.T) {
+	b := newBackoff(1*time.Second, 8*time.Second, 2)
+
+	if _, ok := b.next(); !ok {
+		t.Fatalf("expected first attempt to be allowed")
+	}
+
+	if _, ok := b.next(); !ok {
+		t.Fatalf("expected second attempt to be allowed")
+	}
+
+	if _, ok := b.next(); ok {
+		t.Fatalf("expected third attempt to exceed max-retries")
+	}
+}
+
+// TestBackoffResetStartsOver simulates the "fail, succeed, fail" sequence
+// runRemoteProxyRelay drives: after several failed attempts the delay should
+// have grown, but creating a fresh backoff (what happens when a session runs
+// long enough to be healthy) must start back at the minimum instead of
+// carrying over the inflated attempt count.
+func TestBackoffResetStartsOver(t *testing.T) {
+	b := newBackoff(1*time.Second, 32*time.Second, 0)
+
+	for i := 0; i < 5; i++ {
+		if _, ok := b.next(); !ok {
+			t.Fatalf("next() returned ok=false with no retry budget set")
+		}
+	}
+
+	grown, _ := b.next()
+	if grown < 16*time.Second {
+		t.Fatalf("expected delay to have grown close to backoff-max, got %v", grown)
+	}
+
+	b = newBackoff(1*time.Second, 32*time.Second, 0)
+
+	reset, _ := b.next()
+	if reset > 2*time.Second {
+		t.Fatalf("expected a reset backoff to start near backoff-min, got %v", reset)
+	}
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"net"
 	"time"
@@ -13,9 +15,29 @@ import (
 
 func relayCommand() *cobra.Command {
 	timeout := 5 * time.Second
-	reconnectAfter := time.Duration(0)
+	backoffMin := 1 * time.Second
+	backoffMax := time.Duration(0) // 0 disables reconnecting entirely, matching the old reconnect-after default
+	maxRetries := 0
 	connectionKey := fromEnvWithFallback(ConnectionKeyEnvVariable, defaultConnectionKey)
 	insecure := false
+	protocol := protocolSOCKS5
+	transport := transportTLS
+
+	var (
+		socksUser     string
+		socksPass     string
+		allowCIDRs    []string
+		denyCIDRs     []string
+		allowPorts    []string
+		allowSuffixes []string
+		denySuffixes  []string
+		upstream      string
+		sni           string
+		wsHost        string
+		wsPath        string
+		wsUserAgent   string
+		front         string
+	)
 
 	relayCmd := &cobra.Command{
 		Use:           fmt.Sprintf("%s <connect back address> --key <connection key>", binaryName()),
@@ -24,63 +46,199 @@ func relayCommand() *cobra.Command {
 		SilenceUsage:  true,
 		Args:          cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
+			socksConfig, err := newSocks5Config(socksUser, socksPass, allowCIDRs, denyCIDRs, allowPorts,
+				allowSuffixes, denySuffixes, upstream)
+			if err != nil {
+				return fmt.Errorf("configure socks5: %w", err)
+			}
+
+			if err := validateProtocol(protocol); err != nil {
+				return err
+			}
+
 			return runRemoteProxyRelay(
 				withDefaultPort(args[0], DefaultListenPort), // connect back address
 				connectionKey,
 				timeout,
-				reconnectAfter,
+				backoffMin,
+				backoffMax,
+				maxRetries,
 				insecure,
+				socksConfig,
+				protocol,
+				transport,
+				transportConfig{
+					sni:         sni,
+					wsHost:      wsHost,
+					wsPath:      wsPath,
+					wsUserAgent: wsUserAgent,
+					front:       front,
+				},
 			)
 		},
 	}
 
 	flags := relayCmd.Flags()
 	flags.DurationVar(&timeout, "timeout", timeout, "Connect back timeout")
-	flags.DurationVar(&reconnectAfter, "reconnect-after", reconnectAfter,
-		"Enables reconnect after given duration")
+	flags.DurationVar(&backoffMin, "backoff-min", backoffMin,
+		"Starting delay before reconnecting; jitter scales each delay to between half and all of its value")
+	flags.DurationVar(&backoffMax, "backoff-max", backoffMax,
+		"Maximum delay before reconnecting, growing exponentially from --backoff-min; 0 disables reconnecting")
+	flags.IntVar(&maxRetries, "max-retries", maxRetries, "Give up after this many reconnect attempts (0 means unlimited)")
 	flags.StringVarP(&connectionKey, "key", "k", connectionKey,
 		"Connection key that is displayed when starting a listener")
 	flags.BoolVar(&insecure, "insecure", insecure,
 		"Don't check server certificate and only send client certificate when a connection key is specified")
+	flags.StringVar(&socksUser, "socks-user", socksUser,
+		"Require this username for SOCKS5 authentication (enables RFC 1929 auth)")
+	flags.StringVar(&socksPass, "socks-pass", socksPass,
+		"Require this password for SOCKS5 authentication (enables RFC 1929 auth)")
+	flags.StringSliceVar(&allowCIDRs, "allow-cidr", allowCIDRs,
+		"Only allow SOCKS5 destinations within this CIDR (can be repeated)")
+	flags.StringSliceVar(&denyCIDRs, "deny-cidr", denyCIDRs,
+		"Reject SOCKS5 destinations within this CIDR (can be repeated, evaluated before --allow-cidr)")
+	flags.StringSliceVar(&allowPorts, "allow-port", allowPorts,
+		"Only allow SOCKS5 destination ports in this range, e.g. 443 or 8000-8100 (can be repeated)")
+	flags.StringSliceVar(&allowSuffixes, "allow-suffix", allowSuffixes,
+		"Only allow SOCKS5 destinations whose hostname is or ends in this DNS suffix (can be repeated)")
+	flags.StringSliceVar(&denySuffixes, "deny-suffix", denySuffixes,
+		"Reject SOCKS5 destinations whose hostname is or ends in this DNS suffix (can be repeated, evaluated before --allow-suffix)")
+	flags.StringVar(&protocol, "protocol", protocol,
+		fmt.Sprintf("Proxy protocol to serve: %s, %s or %s", protocolSOCKS5, protocolHTTP, protocolBoth))
+	flags.StringVar(&upstream, "upstream", upstream,
+		"Chain egress through an upstream proxy: socks5://[user:pass@]host:port, http://[user:pass@]host:port, or \"env\" to honor HTTP_PROXY/HTTPS_PROXY/NO_PROXY")
+	flags.StringVar(&transport, "transport", transport,
+		fmt.Sprintf("Transport carrying the control channel: %s, %s or %s", transportTLS, transportWS, transportHTTPConnect))
+	flags.StringVar(&sni, "sni", sni, "Override the TLS ServerName sent to the listener (inside the front's tunnel, with --transport=http-connect)")
+	flags.StringVar(&wsHost, "ws-host", wsHost, "Host header sent on the WebSocket upgrade (--transport=ws)")
+	flags.StringVar(&wsPath, "ws-path", wsPath, "Path used for the WebSocket upgrade (--transport=ws)")
+	flags.StringVar(&wsUserAgent, "ws-user-agent", wsUserAgent, "User-Agent sent on the WebSocket upgrade (--transport=ws)")
+	flags.StringVar(&front, "front", front, "Fronting host:port to CONNECT through before the TLS handshake (--transport=http-connect)")
 
 	return relayCmd
 }
 
+// validateProtocol rejects a --protocol value the relay doesn't know how to
+// serve before any connection is attempted.
+func validateProtocol(protocol string) error {
+	switch protocol {
+	case protocolSOCKS5, protocolHTTP, protocolBoth:
+		return nil
+	default:
+		return fmt.Errorf("unknown protocol %q: want %s, %s or %s", protocol, protocolSOCKS5, protocolHTTP, protocolBoth)
+	}
+}
+
+// newSocks5Config builds the socks5.Config the relay's SOCKS5 server is
+// constructed with, translating the --socks-user/--socks-pass and ACL flags
+// into Credentials and a RuleSet. A zero-value result (no auth, no rules)
+// behaves exactly like the empty config used before these flags existed.
+func newSocks5Config(user, pass string, allowCIDRs, denyCIDRs, allowPorts, allowSuffixes, denySuffixes []string,
+	upstream string,
+) (*socks5.Config, error) {
+	cfg := &socks5.Config{}
+
+	if user != "" || pass != "" {
+		cfg.Credentials = socks5.StaticCredentials{user: pass}
+		cfg.AuthMethods = []socks5.Authenticator{socks5.UserPassAuthenticator{Credentials: cfg.Credentials}}
+	}
+
+	rules, err := newACLRuleSet(allowCIDRs, denyCIDRs, allowPorts, allowSuffixes, denySuffixes)
+	if err != nil {
+		return nil, err
+	}
+
+	if rules != nil {
+		cfg.Rules = rules
+	}
+
+	dial, err := newUpstreamDialer(upstream)
+	if err != nil {
+		return nil, fmt.Errorf("upstream: %w", err)
+	}
+
+	if dial != nil {
+		cfg.Dial = dial
+	}
+
+	return cfg, nil
+}
+
 func runRemoteProxyRelay(connectBackAddr string, connectionKey string, timeout time.Duration,
-	reconnectAfter time.Duration, insecure bool,
+	backoffMin, backoffMax time.Duration, maxRetries int, insecure bool, socksConfig *socks5.Config, protocol string,
+	transportKind string, transportOpts transportConfig,
 ) error {
 	tlsConfig, err := clientTLSConfig(connectionKey, insecure)
 	if err != nil {
 		return fmt.Errorf("build TLS config: %w", err)
 	}
 
+	transportOpts.connectBackAddr = connectBackAddr
+	transportOpts.timeout = timeout
+	transportOpts.tlsConfig = tlsConfig
+
+	transport, err := newTransport(transportKind, transportOpts)
+	if err != nil {
+		return fmt.Errorf("build transport: %w", err)
+	}
+
+	nonce, err := generateSessionNonce()
+	if err != nil {
+		return err
+	}
+
+	retry := newBackoff(backoffMin, backoffMax, maxRetries)
+
 	for {
-		err := connectBackAndRelay(tlsConfig, connectBackAddr, timeout)
+		start := time.Now()
+
+		err := connectBackAndRelay(transport, timeout, socksConfig, protocol, nonce)
 		if err != nil {
-			if reconnectAfter == 0 {
+			if backoffMax == 0 {
 				return err
 			}
 
 			fmt.Printf("error: %v\n", err)
 		}
 
-		if reconnectAfter == 0 {
+		if backoffMax == 0 {
 			return nil
 		}
 
-		fmt.Printf("reconnecting after %v\n", reconnectAfter)
+		// A session that stayed up for at least a full backoff-max cycle is
+		// healthy: don't let it leave the delay pinned high for the next,
+		// unrelated disconnect.
+		if time.Since(start) >= backoffMax {
+			retry = newBackoff(backoffMin, backoffMax, maxRetries)
+		}
+
+		delay, ok := retry.next()
+		if !ok {
+			return fmt.Errorf("giving up after %d reconnect attempts", maxRetries)
+		}
+
+		fmt.Printf("reconnecting in %v\n", delay)
 
-		time.Sleep(reconnectAfter)
+		time.Sleep(delay)
 	}
 }
 
-func connectBackAndRelay(tlsConfig *tls.Config, connectBackAddr string, timeout time.Duration) error {
-	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", connectBackAddr, tlsConfig)
+func connectBackAndRelay(transport Transport, timeout time.Duration,
+	socksConfig *socks5.Config, protocol string, nonce []byte,
+) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	conn, err := transport.Dial(ctx)
 	if err != nil {
 		return fmt.Errorf("dial: %w", err)
 	}
 
-	fmt.Printf("connected to %s\n", conn.RemoteAddr())
+	if netConn, ok := conn.(net.Conn); ok {
+		fmt.Printf("connected to %s\n", netConn.RemoteAddr())
+	} else {
+		fmt.Println("connected")
+	}
 
 	defer conn.Close() //nolint:errcheck
 
@@ -91,12 +249,40 @@ func connectBackAndRelay(tlsConfig *tls.Config, connectBackAddr string, timeout
 
 	defer yamuxServer.Close() //nolint:errcheck
 
-	socksServer, err := socks5.New(&socks5.Config{})
+	if err := sendSessionNonce(yamuxServer, nonce); err != nil {
+		return fmt.Errorf("session nonce: %w", err)
+	}
+
+	if socksConfig == nil {
+		socksConfig = &socks5.Config{}
+	}
+
+	// protocolHTTP needs no SOCKS5 server at all: every stream already
+	// carries a resolved host:port, so the relay just checks and dials it
+	// directly - through the same ACL and upstream dialer the SOCKS5 path
+	// would have used.
+	if protocol == protocolHTTP {
+		return serveFramedConnect(yamuxServer, socksConfig.Dial, socksConfig.Rules, timeout)
+	}
+
+	socksServer, err := socks5.New(socksConfig)
 	if err != nil {
 		return fmt.Errorf("initialize socks5 server: %w", err)
 	}
 
-	err = socksServer.Serve(yamuxServer)
+	var socksListener net.Listener = yamuxServer
+	if protocol == protocolBoth {
+		// In mixed mode every stream is tagged by the listener so the
+		// relay can tell a SOCKS5 connection from a framed CONNECT one.
+		socksListener = &dispatchingListener{
+			Listener: yamuxServer,
+			dial:     socksConfig.Dial,
+			rules:    socksConfig.Rules,
+			timeout:  timeout,
+		}
+	}
+
+	err = socksServer.Serve(socksListener)
 	if err != nil {
 		return fmt.Errorf("socks5 server: %w", err)
 	}
@@ -123,7 +309,7 @@ func clientTLSConfig(connectionKey string, insecure bool) (*tls.Config, error) {
 		return nil, fmt.Errorf("connection key is required")
 	case insecure && connectionKey == "": // don't send client cert and don't check server cert
 		return &tls.Config{InsecureSkipVerify: true}, nil //nolint:gosec
-	case insecure && connectionKey != "": // send client cert but don't check server cert
+	case insecure && connectionKey != "": // send client cert, but pin the server cert instead of PKI/hostname checks
 		key, err := ParseConnectionKey(connectionKey)
 		if err != nil {
 			return nil, fmt.Errorf("parse connection key: %w", err)
@@ -134,9 +320,51 @@ func clientTLSConfig(connectionKey string, insecure bool) (*tls.Config, error) {
 			return nil, fmt.Errorf("configure TLS: %w", err)
 		}
 
-		cfg.InsecureSkipVerify = true
+		cfg.InsecureSkipVerify = true //nolint:gosec // VerifyConnection below pins the server cert instead
 		cfg.ServerName = ""
 
+		verify, err := pinnedServerVerifier(cfg.RootCAs)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.VerifyConnection = verify
+
 		return cfg, nil
 	}
 }
+
+// pinnedServerVerifier pins the server for --insecure connections that carry
+// a connection key: with InsecureSkipVerify set, Go's tls package performs no
+// certificate validation at all, so without this the client cert would be
+// sent to literally any peer. It skips only the hostname check and verifies
+// the presented chain against roots, the same trusted material non-insecure
+// mode already validates the server against; a certificate that does not
+// chain to roots is rejected, even on the first connection.
+func pinnedServerVerifier(roots *x509.CertPool) (func(tls.ConnectionState) error, error) {
+	if roots == nil {
+		return nil, fmt.Errorf("connection key has no trusted root to pin the server certificate against")
+	}
+
+	return func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return fmt.Errorf("pinned verify: server presented no certificate")
+		}
+
+		intermediates := x509.NewCertPool()
+		for _, cert := range cs.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+
+		_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+		if err != nil {
+			return fmt.Errorf("pinned verify: %w", err)
+		}
+
+		return nil
+	}, nil
+}
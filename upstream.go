@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/proxy"
+)
+
+// dialFunc matches socks5.Config.Dial, letting the relay's egress be chained
+// through an upstream proxy instead of dialing destinations directly.
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// newUpstreamDialer builds the dial function for the --upstream flag. raw may
+// be a socks5:// or http(s):// URL with optional user:pass credentials, or
+// the literal "env" to honor HTTP_PROXY/HTTPS_PROXY/NO_PROXY the way the
+// standard library's http.Transport does. An empty raw returns a nil
+// dialFunc, leaving socks5.Config.Dial unset so go-socks5 falls back to its
+// own direct net.Dialer.
+func newUpstreamDialer(raw string) (dialFunc, error) {
+	if raw == "" {
+		return nil, nil
+	}
+
+	if raw == "env" {
+		return envProxyDial, nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("parse upstream URL: %w", err)
+	}
+
+	return dialerForURL(u)
+}
+
+// dialerForURL picks the right dial implementation for a parsed upstream
+// proxy URL: golang.org/x/net/proxy already implements socks5://, but it has
+// no http:// dialer, so that scheme is handled by httpConnectDial.
+func dialerForURL(u *url.URL) (dialFunc, error) {
+	switch u.Scheme {
+	case "http", "https":
+		return httpConnectDial(u), nil
+	default:
+		d, err := proxy.FromURL(u, proxy.Direct)
+		if err != nil {
+			return nil, fmt.Errorf("build upstream dialer: %w", err)
+		}
+
+		return contextDial(d), nil
+	}
+}
+
+// contextDial adapts a golang.org/x/net/proxy.Dialer, which predates
+// contexts, to dialFunc.
+func contextDial(d proxy.Dialer) dialFunc {
+	if ctxDialer, ok := d.(proxy.ContextDialer); ok {
+		return ctxDialer.DialContext
+	}
+
+	return func(_ context.Context, network, addr string) (net.Conn, error) {
+		return d.Dial(network, addr)
+	}
+}
+
+// envProxyDial resolves HTTP_PROXY/HTTPS_PROXY/NO_PROXY for each destination
+// the way http.ProxyFromEnvironment does, preferring HTTPS_PROXY and falling
+// back to HTTP_PROXY, and dials through whatever it returns (or direct, when
+// neither is set or the destination is excluded via NO_PROXY).
+func envProxyDial(ctx context.Context, network, addr string) (net.Conn, error) {
+	proxyURL, err := http.ProxyFromEnvironment(&http.Request{URL: &url.URL{Scheme: "https", Host: addr}})
+	if err == nil && proxyURL == nil {
+		proxyURL, err = http.ProxyFromEnvironment(&http.Request{URL: &url.URL{Scheme: "http", Host: addr}})
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("resolve proxy from environment: %w", err)
+	}
+
+	if proxyURL == nil {
+		return (&net.Dialer{}).DialContext(ctx, network, addr)
+	}
+
+	dial, err := dialerForURL(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("build dialer from environment proxy: %w", err)
+	}
+
+	return dial(ctx, network, addr)
+}
+
+// httpConnectDial builds a dialFunc that reaches addr by issuing an HTTP
+// CONNECT request to proxyURL, since golang.org/x/net/proxy doesn't ship an
+// HTTP CONNECT dialer.
+func httpConnectDial(proxyURL *url.URL) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := (&net.Dialer{}).DialContext(ctx, network, proxyURL.Host)
+		if err != nil {
+			return nil, fmt.Errorf("dial upstream proxy %s: %w", proxyURL.Host, err)
+		}
+
+		if proxyURL.Scheme == "https" {
+			tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()}) //nolint:gosec
+			if err := tlsConn.HandshakeContext(ctx); err != nil {
+				conn.Close() //nolint:errcheck
+
+				return nil, fmt.Errorf("tls handshake with upstream proxy %s: %w", proxyURL.Host, err)
+			}
+
+			conn = tlsConn
+		}
+
+		req := &http.Request{
+			Method: http.MethodConnect,
+			URL:    &url.URL{Opaque: addr},
+			Host:   addr,
+			Header: make(http.Header),
+		}
+
+		if proxyURL.User != nil {
+			req.Header.Set("Proxy-Authorization", "Basic "+basicAuth(proxyURL.User))
+		}
+
+		if err := req.Write(conn); err != nil {
+			conn.Close() //nolint:errcheck
+
+			return nil, fmt.Errorf("write CONNECT request: %w", err)
+		}
+
+		resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+		if err != nil {
+			conn.Close() //nolint:errcheck
+
+			return nil, fmt.Errorf("read CONNECT response: %w", err)
+		}
+
+		defer resp.Body.Close() //nolint:errcheck
+
+		if resp.StatusCode != http.StatusOK {
+			conn.Close() //nolint:errcheck
+
+			return nil, fmt.Errorf("upstream proxy CONNECT failed: %s", resp.Status)
+		}
+
+		return conn, nil
+	}
+}
+
+func basicAuth(u *url.Userinfo) string {
+	pass, _ := u.Password()
+
+	return base64.StdEncoding.EncodeToString([]byte(u.Username() + ":" + pass))
+}
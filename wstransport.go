@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsTransport carries yamux over a WebSocket connection upgraded from HTTPS,
+// so the control channel looks like ordinary web traffic to middleboxes and
+// DPI instead of a bare TLS stream.
+type wsTransport struct {
+	cfg transportConfig
+}
+
+func (t *wsTransport) Dial(ctx context.Context) (io.ReadWriteCloser, error) {
+	host := t.cfg.wsHost
+	if host == "" {
+		host = t.cfg.connectBackAddr
+	}
+
+	u := url.URL{Scheme: "wss", Host: t.cfg.connectBackAddr, Path: t.cfg.wsPath}
+
+	header := http.Header{}
+	header.Set("Host", host)
+
+	if t.cfg.wsUserAgent != "" {
+		header.Set("User-Agent", t.cfg.wsUserAgent)
+	}
+
+	tlsConfig := t.cfg.tlsConfig.Clone()
+
+	// Only override ServerName when --sni or --ws-host was explicitly given;
+	// otherwise leave whatever ClientTLSConfig already put there (the
+	// connection key's pinned name), same as tlsTransport and
+	// httpFrontTransport.
+	switch {
+	case t.cfg.sni != "":
+		tlsConfig.ServerName = t.cfg.sni
+	case t.cfg.wsHost != "":
+		serverName := t.cfg.wsHost
+		if h, _, err := net.SplitHostPort(serverName); err == nil {
+			serverName = h
+		}
+
+		tlsConfig.ServerName = serverName
+	}
+
+	dialer := &websocket.Dialer{
+		TLSClientConfig:  tlsConfig,
+		HandshakeTimeout: t.cfg.timeout,
+	}
+
+	conn, _, err := dialer.DialContext(ctx, u.String(), header)
+	if err != nil {
+		return nil, fmt.Errorf("websocket dial: %w", err)
+	}
+
+	return &wsConn{Conn: conn}, nil
+}
+
+// wsConn adapts a message-oriented *websocket.Conn to the byte-stream
+// io.ReadWriteCloser yamux expects, reassembling reads across message
+// boundaries.
+type wsConn struct {
+	*websocket.Conn
+
+	pending []byte
+}
+
+func (c *wsConn) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		_, data, err := c.Conn.ReadMessage()
+		if err != nil {
+			return 0, err
+		}
+
+		c.pending = data
+	}
+
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+
+	return n, nil
+}
+
+func (c *wsConn) Write(p []byte) (int, error) {
+	if err := c.Conn.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}